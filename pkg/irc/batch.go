@@ -0,0 +1,93 @@
+package irc
+
+import "sync"
+
+// Batch aggregates the messages belonging to a single IRCv3 "batch" (see
+// the batch extension), such as a CHATHISTORY playback or a netsplit. Type
+// is the batch's first parameter (e.g. "chathistory", "netsplit") and
+// Params holds whatever follows it.
+type Batch struct {
+	Reference string
+	Type      string
+	Params    []string
+	Messages  []*Message
+	Parent    *Batch
+}
+
+// batcher collects in-progress batches by their reference tag until the
+// matching BATCH -ref end line closes them out.
+type batcher struct {
+	mu   sync.Mutex
+	open map[string]*Batch
+}
+
+func newBatcher() *batcher {
+	return &batcher{open: make(map[string]*Batch)}
+}
+
+// handle folds msg into the batch tracker. If msg is itself a BATCH
+// start/end line it returns (nil, false) and the caller should not deliver
+// it as a regular message. If msg closes a batch, the completed Batch is
+// returned so the caller can deliver it as a unit. Messages tagged with an
+// open batch reference are appended to that batch and also return
+// (nil, false), since they are delivered as part of the batch rather than
+// individually.
+func (b *batcher) handle(msg *Message) (*Batch, bool) {
+	if msg.Command == "BATCH" && len(msg.Params) > 0 {
+		ref := msg.Params[0]
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if len(ref) > 0 && ref[0] == '-' {
+			ref = ref[1:]
+			batch, ok := b.open[ref]
+			if !ok {
+				return nil, false
+			}
+			delete(b.open, ref)
+
+			if batch.Parent != nil {
+				// Nested batch: folded into the parent's Messages rather
+				// than delivered on its own.
+				batch.Parent.Messages = append(batch.Parent.Messages, batch.Messages...)
+				return nil, false
+			}
+
+			return batch, true
+		}
+
+		batch := &Batch{Reference: ref}
+		if len(msg.Params) > 1 {
+			batch.Type = msg.Params[1]
+		}
+		if len(msg.Params) > 2 {
+			batch.Params = msg.Params[2:]
+		}
+
+		if parentRef, ok := msg.Tags.Get("batch"); ok {
+			if parent, ok := b.open[parentRef]; ok {
+				batch.Parent = parent
+			}
+		}
+
+		b.open[ref] = batch
+		return nil, false
+	}
+
+	ref, ok := msg.Tags.Get("batch")
+	if !ok {
+		return nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch, ok := b.open[ref]
+	if !ok {
+		return nil, false
+	}
+
+	batch.Messages = append(batch.Messages, msg)
+	return nil, false
+}
@@ -0,0 +1,100 @@
+package irc
+
+// capsRequested lists the capabilities negotiated during registration,
+// in addition to "sasl" which handleCap adds to the CAP REQ when
+// Config.SASL is set and the server advertises it.
+var capsRequested = []string{
+	"message-tags",
+	"server-time",
+	"batch",
+	"labeled-response",
+	"away-notify",
+}
+
+// negotiateCaps runs the CAP LS/REQ/END exchange. It is invoked from
+// register() before NICK/USER so that message-tags and server-time are in
+// effect for the rest of registration, mirroring the way initSASL hooks
+// into the same handshake.
+func (c *Client) negotiateCaps() {
+	c.Write("CAP LS 302")
+}
+
+// handleCap processes CAP subcommands seen in handleMessage. avail is the
+// set of capabilities the server advertised via CAP LS; ack/nak report the
+// server's response to our CAP REQ.
+func (c *Client) handleCap(msg *Message) {
+	if len(msg.Params) < 2 {
+		return
+	}
+
+	switch msg.Params[1] {
+	case "LS":
+		avail := ParseCapList(msg.Trailing)
+
+		var req []string
+		for _, cap := range capsRequested {
+			if _, ok := avail[cap]; ok {
+				req = append(req, cap)
+			}
+		}
+
+		if c.Config.SASL != nil {
+			if _, ok := avail["sasl"]; ok {
+				req = append(req, "sasl")
+			}
+		}
+
+		if len(req) > 0 {
+			c.Writef("CAP REQ :%s", joinCaps(req))
+		} else {
+			c.Write("CAP END")
+		}
+
+	case "ACK", "NAK":
+		acked := ParseCapList(msg.Trailing)
+		c.state.setCaps(acked, msg.Params[1] == "ACK")
+
+		if _, ok := acked["sasl"]; ok && msg.Params[1] == "ACK" {
+			c.initSASL()
+			return
+		}
+
+		c.Write("CAP END")
+	}
+}
+
+// ParseCapList parses the space-separated capability list carried in CAP
+// LS/ACK/NAK trailing parameters, stripping the "=value" suffix CAP LS 302
+// attaches to capabilities that advertise a value.
+func ParseCapList(raw string) map[string]string {
+	caps := make(map[string]string)
+
+	start := 0
+	for i := 0; i <= len(raw); i++ {
+		if i == len(raw) || raw[i] == ' ' {
+			if i > start {
+				token := raw[start:i]
+				name := token
+				value := ""
+				for j := 0; j < len(token); j++ {
+					if token[j] == '=' {
+						name, value = token[:j], token[j+1:]
+						break
+					}
+				}
+				caps[name] = value
+			}
+			start = i + 1
+		}
+	}
+
+	return caps
+}
+
+func joinCaps(caps []string) string {
+	out := caps[0]
+	for _, cap := range caps[1:] {
+		out += " " + cap
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config holds the settings used to dial and register a Client.
+type Config struct {
+	Host string
+	Port string
+
+	TLS       bool
+	TLSConfig *tls.Config
+
+	Nick     string
+	Username string
+	Realname string
+	Password string
+
+	SASL            *SASLConfig
+	ConnectCommands []string
+
+	DCCAdvertiseIP string
+	DCCPortRange   [2]int
+
+	// DCCPassive makes DCCSend offer passive/reverse DCC (port 0 plus a
+	// token) instead of listening itself, for senders behind NAT with no
+	// forwarded port range.
+	DCCPassive bool
+}
+
+// SASLConfig selects and configures the SASL mechanism negotiated during
+// registration. Mechanism is one of "PLAIN", "EXTERNAL", or
+// "SCRAM-SHA-256"; Username/Password are ignored by EXTERNAL, which
+// authenticates via the client certificate in Config.TLSConfig instead.
+type SASLConfig struct {
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// Client is a connection to a single IRC network.
+type Client struct {
+	Config Config
+
+	Messages          chan *Message
+	Batches           chan *Batch
+	DCC               chan *DCCOffer
+	ConnectionChanged chan ConnectionState
+
+	conn    net.Conn
+	dialer  Dialer
+	scan    *bufio.Scanner
+	recvBuf []byte
+
+	out       chan string
+	quit      chan struct{}
+	reconnect chan struct{}
+
+	sendRecv  sync.WaitGroup
+	lock      sync.Mutex
+	connected bool
+
+	regLock    sync.Mutex
+	registered bool
+
+	backoff backoff
+	state   state
+	batches *batcher
+
+	scram *scramState
+
+	dccMu      sync.Mutex
+	dccPending map[string]chan *DCCOffer
+}
+
+// NewClient creates a Client ready to Connect with the given config.
+func NewClient(config Config) *Client {
+	return &Client{
+		Config: config,
+
+		Messages:          make(chan *Message, 32),
+		Batches:           make(chan *Batch, 8),
+		DCC:               make(chan *DCCOffer, 8),
+		ConnectionChanged: make(chan ConnectionState, 8),
+
+		dialer:  DefaultDialer,
+		recvBuf: make([]byte, 0, 64*1024),
+
+		out:       make(chan string, 32),
+		quit:      make(chan struct{}),
+		reconnect: make(chan struct{}),
+
+		batches: newBatcher(),
+	}
+}
+
+// Connected reports whether the underlying connection is currently up.
+func (c *Client) Connected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.connected
+}
+
+func (c *Client) setRegistered(v bool) {
+	c.regLock.Lock()
+	c.registered = v
+	c.regLock.Unlock()
+}
+
+// Registered reports whether the client has completed IRC registration
+// (received RPL_WELCOME) on the current connection.
+func (c *Client) Registered() bool {
+	c.regLock.Lock()
+	defer c.regLock.Unlock()
+
+	return c.registered
+}
+
+// backoff is a simple doubling backoff with a two-minute cap, used between
+// reconnect attempts.
+type backoff struct {
+	n int
+}
+
+func (b *backoff) Duration() time.Duration {
+	d := time.Duration(1<<uint(b.n)) * time.Second
+	if d > 2*time.Minute {
+		d = 2 * time.Minute
+	}
+
+	if d < 2*time.Minute {
+		b.n++
+	}
+
+	return d
+}
+
+func (b *backoff) reset() {
+	b.n = 0
+}
+
+// state tracks per-connection negotiation state that must be cleared on
+// reconnect, such as acknowledged capabilities.
+type state struct {
+	mu   sync.Mutex
+	caps map[string]bool
+}
+
+func (s *state) reset() {
+	s.mu.Lock()
+	s.caps = nil
+	s.mu.Unlock()
+}
+
+func (s *state) setCaps(caps map[string]string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.caps == nil {
+		s.caps = make(map[string]bool)
+	}
+
+	for name := range caps {
+		s.caps[name] = on
+	}
+}
+
+func (s *state) hasCap(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.caps[name]
+}
@@ -132,6 +132,9 @@ func (c *Client) connect() error {
 	if c.Config.TLS {
 		c.Config.TLSConfig.ServerName = c.Config.Host
 
+		// Config.TLSConfig.Certificates, when set, is presented during the
+		// handshake below; it is what SASL EXTERNAL (CertFP) authenticates
+		// with when Config.SASL.Mechanism is "EXTERNAL".
 		tlsConn := tls.Client(conn, c.Config.TLSConfig)
 		err = tlsConn.Handshake()
 		if err != nil {
@@ -148,7 +151,8 @@ func (c *Client) connect() error {
 
 	go c.register()
 
-	c.sendRecv.Add(1)
+	c.sendRecv.Add(2)
+	go c.send()
 	go c.recv()
 
 	return nil
@@ -195,15 +199,34 @@ func (c *Client) recv() {
 			continue
 		}
 
-		msg := ParseMessage(string(b))
+		tags, line, hasTags := splitTags(string(b))
+
+		msg := ParseMessage(line)
 		if msg == nil {
 			c.connChange(false, ErrBadProtocol)
 			close(c.quit)
 			return
 		}
+		if hasTags {
+			msg.Tags = tags
+		}
 
 		c.handleMessage(msg)
 
+		if batch, ok := c.batches.handle(msg); ok {
+			c.Batches <- batch
+			continue
+		}
+
+		// BATCH lines and messages carrying a batch tag are not delivered
+		// individually; those in an open batch reach consumers in its Batch.
+		if msg.Command == "BATCH" {
+			continue
+		}
+		if _, inBatch := msg.Tags.Get("batch"); inBatch {
+			continue
+		}
+
 		c.Messages <- msg
 	}
 }
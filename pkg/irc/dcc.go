@@ -0,0 +1,451 @@
+package irc
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dccAcceptTimeout bounds how long DCCSend waits for a passive offer's
+// connect-back message before giving up.
+const dccAcceptTimeout = 2 * time.Minute
+
+// ErrDCCDeclined reports that a DCC offer is no longer available. Neither
+// Accept nor DCCAccept returns it.
+var ErrDCCDeclined = errors.New("irc: DCC offer no longer available")
+
+// DCCOffer is a parsed incoming CTCP DCC request, delivered on
+// Client.DCC. Accept dials SEND/CHAT offers; passive (reverse) offers are
+// accepted by the remote end instead, so Accept only listens for those.
+type DCCOffer struct {
+	Nick string
+	Type string // "CHAT", "SEND", "RESUME", or "ACCEPT"
+	File string
+	IP   net.IP
+	Port int
+	Size int64
+
+	// Token is set for passive/reverse DCC: on the port-0 offer itself,
+	// and on the DCC SEND that answers it with the receiver's own address
+	// and port, which the offering side matches by this token.
+	Token string
+
+	client *Client
+}
+
+// parseDCCOffer parses the CTCP payload of a "DCC ..." PRIVMSG, as sent by
+// handleMessage when it sees a CTCP DCC request from nick.
+func parseDCCOffer(c *Client, nick, payload string) (*DCCOffer, error) {
+	fields := strings.Fields(payload)
+	if len(fields) < 2 || fields[0] != "DCC" {
+		return nil, errors.New("irc: not a DCC request")
+	}
+
+	offer := &DCCOffer{Nick: nick, Type: strings.ToUpper(fields[1]), client: c}
+
+	switch offer.Type {
+	case "CHAT":
+		if len(fields) < 5 {
+			return nil, errors.New("irc: malformed DCC CHAT")
+		}
+		offer.File = fields[2]
+		offer.IP = unpackDCCAddress(fields[3])
+		offer.Port, _ = strconv.Atoi(fields[4])
+
+	case "SEND":
+		if len(fields) < 5 {
+			return nil, errors.New("irc: malformed DCC SEND")
+		}
+		offer.File = fields[2]
+		offer.IP = unpackDCCAddress(fields[3])
+		offer.Port, _ = strconv.Atoi(fields[4])
+		if len(fields) > 5 {
+			offer.Size, _ = strconv.ParseInt(fields[5], 10, 64)
+		}
+		if len(fields) > 6 {
+			offer.Token = fields[6]
+		}
+
+	case "RESUME":
+		if len(fields) < 4 {
+			return nil, errors.New("irc: malformed DCC RESUME")
+		}
+		offer.File = fields[2]
+		offer.Port, _ = strconv.Atoi(fields[3])
+		if len(fields) > 4 {
+			offer.Size, _ = strconv.ParseInt(fields[4], 10, 64)
+		}
+		if len(fields) > 5 {
+			offer.Token = fields[5]
+		}
+
+	case "ACCEPT":
+		if len(fields) < 4 {
+			return nil, errors.New("irc: malformed DCC ACCEPT")
+		}
+		offer.File = fields[2]
+		offer.Port, _ = strconv.Atoi(fields[3])
+		if len(fields) > 4 {
+			offer.Size, _ = strconv.ParseInt(fields[4], 10, 64)
+		}
+		if len(fields) > 5 {
+			offer.Token = fields[5]
+		}
+
+	default:
+		return nil, fmt.Errorf("irc: unsupported DCC subcommand %q", offer.Type)
+	}
+
+	return offer, nil
+}
+
+// unpackDCCAddress decodes the classic DCC 32-bit packed-IP form used by
+// most clients, falling back to treating the field as a literal address
+// for clients that send dotted-quad IPs instead.
+func unpackDCCAddress(field string) net.IP {
+	n, err := strconv.ParseUint(field, 10, 32)
+	if err != nil {
+		return net.ParseIP(field)
+	}
+
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, uint32(n))
+	return ip
+}
+
+// packDCCAddress encodes ip as the classic DCC 32-bit packed form, which
+// only exists for IPv4. It errors rather than panicking on an unparsable
+// or IPv6 address, which a misconfigured Config.DCCAdvertiseIP can easily
+// produce.
+func packDCCAddress(ip net.IP) (uint32, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, fmt.Errorf("irc: %v is not a valid IPv4 address for DCC", ip)
+	}
+
+	return binary.BigEndian.Uint32(ip4), nil
+}
+
+// dispatchDCC inspects a PRIVMSG for a CTCP DCC request and, if found,
+// parses it and delivers it on Client.DCC. It's called from handleMessage,
+// which then skips its other handling; recv still delivers the PRIVMSG
+// itself on Messages as well. ok is false for ordinary messages, which the
+// caller should continue dispatching as usual.
+func (c *Client) dispatchDCC(msg *Message) (ok bool) {
+	if msg.Command != "PRIVMSG" || len(msg.Params) < 2 {
+		return false
+	}
+
+	trailing := msg.Params[len(msg.Params)-1]
+	if len(trailing) < 2 || trailing[0] != '\x01' || trailing[len(trailing)-1] != '\x01' {
+		return false
+	}
+
+	payload := trailing[1 : len(trailing)-1]
+	if !strings.HasPrefix(payload, "DCC ") {
+		return false
+	}
+
+	nick := msg.Prefix
+	if i := strings.IndexByte(nick, '!'); i > 0 {
+		nick = nick[:i]
+	}
+
+	offer, err := parseDCCOffer(c, nick, payload)
+	if err != nil {
+		return false
+	}
+
+	if offer.Token != "" && c.resolveDCCAccept(offer) {
+		return true
+	}
+
+	c.DCC <- offer
+	return true
+}
+
+// resolveDCCAccept checks whether offer is the connect-back message a
+// pending DCCSend passive transfer is waiting for, and if so delivers it
+// there instead of the general DCC channel.
+func (c *Client) resolveDCCAccept(offer *DCCOffer) bool {
+	c.dccMu.Lock()
+	ch, ok := c.dccPending[offer.Token]
+	if ok {
+		delete(c.dccPending, offer.Token)
+	}
+	c.dccMu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	ch <- offer
+	return true
+}
+
+func (c *Client) awaitDCCAccept(token string) chan *DCCOffer {
+	ch := make(chan *DCCOffer, 1)
+
+	c.dccMu.Lock()
+	if c.dccPending == nil {
+		c.dccPending = make(map[string]chan *DCCOffer)
+	}
+	c.dccPending[token] = ch
+	c.dccMu.Unlock()
+
+	return ch
+}
+
+func (c *Client) cancelDCCAccept(token string) {
+	c.dccMu.Lock()
+	delete(c.dccPending, token)
+	c.dccMu.Unlock()
+}
+
+func dccToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Accept dials an active (non-passive) DCC CHAT or SEND offer and streams
+// the transfer into w, acknowledging every chunk with the 4-byte
+// big-endian byte count the classic DCC protocol expects. Passive offers
+// (Token set) must be accepted with (*Client).DCCAccept instead, since
+// accepting one means listening and connecting back to the sender rather
+// than dialing it directly.
+func (o *DCCOffer) Accept(w io.Writer) error {
+	if o.Token != "" {
+		return o.client.DCCAccept(o, w)
+	}
+
+	conn, err := DefaultDialer.Dial("tcp", net.JoinHostPort(o.IP.String(), strconv.Itoa(o.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return dccStream(conn, w)
+}
+
+// DCCAccept accepts a passive/reverse DCC offer (Token set because the
+// sender offered port 0). Since the sender can't be dialed directly, we
+// listen instead and send back our own address under the same token; the
+// sender connects to us once it sees that connect-back message.
+func (c *Client) DCCAccept(o *DCCOffer, w io.Writer) error {
+	if o.Token == "" {
+		return errors.New("irc: offer is not a passive DCC offer")
+	}
+
+	packedIP, ln, port, err := c.listenForDCCConnectBack()
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	c.Writef("PRIVMSG %s :\x01DCC SEND %s %d %d %d %s\x01", o.Nick, o.File, packedIP, port, o.Size, o.Token)
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return dccStream(conn, w)
+}
+
+// listenForDCCConnectBack validates Config.DCCAdvertiseIP and opens a
+// listener from Config.DCCPortRange, for the listening step shared by
+// DCCAccept and the non-passive branch of DCCSend.
+func (c *Client) listenForDCCConnectBack() (packedIP uint32, ln net.Listener, port int, err error) {
+	if c.Config.DCCAdvertiseIP == "" {
+		return 0, nil, 0, errors.New("irc: Config.DCCAdvertiseIP must be set to listen for DCC")
+	}
+
+	ip := net.ParseIP(c.Config.DCCAdvertiseIP)
+	if ip == nil {
+		return 0, nil, 0, fmt.Errorf("irc: Config.DCCAdvertiseIP %q is not a valid IP address", c.Config.DCCAdvertiseIP)
+	}
+
+	packedIP, err = packDCCAddress(ip)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	ln, port, err = listenDCCPortRange(c.Config.DCCPortRange)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	return packedIP, ln, port, nil
+}
+
+// dccStream copies from conn into w, sending the classic DCC 4-byte
+// big-endian running-total acknowledgment after every chunk received.
+func dccStream(conn net.Conn, w io.Writer) error {
+	buf := make([]byte, 32*1024)
+	var total uint32
+	ack := make([]byte, 4)
+
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+
+			total += uint32(n)
+			binary.BigEndian.PutUint32(ack, total)
+			if _, werr := conn.Write(ack); werr != nil {
+				return werr
+			}
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// DCCSend offers path to nick over DCC SEND and streams it once a
+// connection arrives. With Config.DCCPassive false (the default) it
+// listens on a port chosen from Config.DCCPortRange and advertises
+// Config.DCCAdvertiseIP in the offer, since the listening socket's local
+// address is rarely the address reachable by the peer. With
+// Config.DCCPassive true it instead sends port 0 plus a token and waits
+// for the peer's connect-back DCC SEND carrying its own address, for
+// senders behind NAT with no forwarded port range.
+func (c *Client) DCCSend(nick, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := info.Name()
+
+	if c.Config.DCCPassive {
+		return c.dccSendPassive(nick, name, info.Size(), f)
+	}
+
+	packedIP, ln, port, err := c.listenForDCCConnectBack()
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	c.Writef("PRIVMSG %s :\x01DCC SEND %s %d %d %d\x01", nick, name, packedIP, port, info.Size())
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return dccSendStream(f, conn)
+}
+
+// dccSendPassive implements the Config.DCCPassive branch of DCCSend: it
+// offers port 0 with a token, waits for the peer to connect back to us
+// with its own DCC SEND message sharing that token (the peer now acts as
+// the listener), then dials the peer and streams the file.
+func (c *Client) dccSendPassive(nick, name string, size int64, f *os.File) error {
+	if c.Config.DCCAdvertiseIP == "" {
+		return errors.New("irc: Config.DCCAdvertiseIP must be set to offer DCC SEND")
+	}
+
+	ip := net.ParseIP(c.Config.DCCAdvertiseIP)
+	if ip == nil {
+		return fmt.Errorf("irc: Config.DCCAdvertiseIP %q is not a valid IP address", c.Config.DCCAdvertiseIP)
+	}
+
+	packedIP, err := packDCCAddress(ip)
+	if err != nil {
+		return err
+	}
+
+	token := dccToken()
+	connectBack := c.awaitDCCAccept(token)
+	defer c.cancelDCCAccept(token)
+
+	c.Writef("PRIVMSG %s :\x01DCC SEND %s %d 0 %d %s\x01", nick, name, packedIP, size, token)
+
+	var peer *DCCOffer
+	select {
+	case peer = <-connectBack:
+	case <-time.After(dccAcceptTimeout):
+		return errors.New("irc: timed out waiting for the passive DCC SEND connect-back")
+	}
+
+	conn, err := DefaultDialer.Dial("tcp", net.JoinHostPort(peer.IP.String(), strconv.Itoa(peer.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return dccSendStream(f, conn)
+}
+
+// dccSendStream sends the contents of f to conn, reading back the classic
+// DCC 4-byte big-endian acknowledgment after every chunk.
+func dccSendStream(f *os.File, conn net.Conn) error {
+	buf := make([]byte, 32*1024)
+	ack := make([]byte, 4)
+
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return werr
+			}
+
+			if _, rerr := io.ReadFull(conn, ack); rerr != nil {
+				return rerr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// listenDCCPortRange opens a listener on the first free port in portRange
+// (inclusive), or any free port if portRange is empty.
+func listenDCCPortRange(portRange [2]int) (net.Listener, int, error) {
+	if portRange == ([2]int{}) {
+		ln, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return nil, 0, err
+		}
+		return ln, ln.Addr().(*net.TCPAddr).Port, nil
+	}
+
+	for p := portRange[0]; p <= portRange[1]; p++ {
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(p))
+		if err == nil {
+			return ln, p, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("irc: no free port in DCC range %d-%d", portRange[0], portRange[1])
+}
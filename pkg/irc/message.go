@@ -0,0 +1,83 @@
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+// Message is a single parsed IRC line, with any leading message-tags
+// prefix split out into Tags.
+type Message struct {
+	Tags     Tags
+	Prefix   string
+	Command  string
+	Params   []string
+	Trailing string
+}
+
+// Nick returns the nick portion of Prefix, which may otherwise be a full
+// nick!user@host hostmask or a server name.
+func (m *Message) Nick() string {
+	if i := strings.IndexByte(m.Prefix, '!'); i > 0 {
+		return m.Prefix[:i]
+	}
+
+	return m.Prefix
+}
+
+// Time returns the server-time this message was sent at, from its "time"
+// tag, falling back to the local time it was received if the tag is
+// absent or malformed (e.g. the server doesn't support server-time).
+// Callers logging a Message should use this rather than time.Now(), so
+// replayed history (bouncers, CHATHISTORY) keeps its original ordering.
+func (m *Message) Time() time.Time {
+	if t, ok := m.Tags.Time(); ok {
+		return t
+	}
+
+	return time.Now()
+}
+
+// ParseMessage parses a single IRC line, excluding any message-tags prefix
+// (see splitTags). It returns nil if line isn't a well-formed IRC message.
+func ParseMessage(line string) *Message {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil
+	}
+
+	msg := &Message{}
+	cmdStart := 0
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil
+		}
+
+		msg.Prefix = line[1:i]
+		cmdStart = i + 1
+	}
+
+	cmdEnd := len(line)
+	if i := strings.Index(line[cmdStart:], " :"); i >= 0 {
+		cmdEnd = cmdStart + i
+		msg.Trailing = line[cmdEnd+2:]
+	}
+
+	fields := strings.Fields(line[cmdStart:cmdEnd])
+	if len(fields) == 0 {
+		return nil
+	}
+
+	msg.Command = fields[0]
+	if len(fields) > 1 {
+		msg.Params = fields[1:]
+	}
+
+	if cmdEnd < len(line) {
+		msg.Params = append(msg.Params, msg.Trailing)
+	}
+
+	return msg
+}
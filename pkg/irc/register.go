@@ -0,0 +1,66 @@
+package irc
+
+// register runs after a successful connect. It starts capability
+// negotiation and sends the registration commands; the rest of
+// registration (SASL, RPL_WELCOME) is driven by handleMessage as the
+// server responds.
+func (c *Client) register() {
+	c.negotiateCaps()
+
+	if c.Config.Password != "" {
+		c.Write("PASS " + c.Config.Password)
+	}
+
+	c.Write("NICK " + c.Config.Nick)
+	c.Writef("USER %s 0 * :%s", c.Config.Username, c.Config.Realname)
+}
+
+// handleMessage updates client state from an incoming message before it is
+// delivered to consumers on Messages/Batches.
+func (c *Client) handleMessage(msg *Message) {
+	if c.dispatchDCC(msg) {
+		return
+	}
+
+	switch msg.Command {
+	case "PING":
+		c.Write("PONG :" + msg.Trailing)
+
+	case "CAP":
+		c.handleCap(msg)
+
+	case "AUTHENTICATE":
+		c.handleAuthenticate(msg)
+
+	// RPL_SASLSUCCESS; the NAK codes (904-907) fall through to CAP END so
+	// registration isn't stuck waiting on a failed exchange.
+	case "903", "904", "905", "906", "907":
+		c.Write("CAP END")
+
+	case "001":
+		c.setRegistered(true)
+		c.runConnectCommands()
+
+	default:
+	}
+}
+
+// initSASL starts the configured SASL exchange once the server ACKs the
+// sasl capability.
+func (c *Client) initSASL() {
+	if c.Config.SASL == nil {
+		c.Write("CAP END")
+		return
+	}
+
+	switch c.Config.SASL.Mechanism {
+	case "EXTERNAL":
+		c.beginSASLExternal()
+
+	case "SCRAM-SHA-256":
+		c.scram = c.beginSASLScram(c.Config.SASL.Username, c.Config.SASL.Password)
+
+	default:
+		c.Write("AUTHENTICATE PLAIN")
+	}
+}
@@ -0,0 +1,250 @@
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrSASLFailed is returned when the server rejects authentication or a
+// SCRAM exchange fails local verification.
+var ErrSASLFailed = errors.New("irc: SASL authentication failed")
+
+// saslAuthenticateLineLen is the maximum payload size of a single
+// AUTHENTICATE line; longer payloads are split across multiple lines and
+// terminated with a lone "+" once the remainder is empty.
+const saslAuthenticateLineLen = 400
+
+// abortSASL reports a fatal SASL failure via ConnectionChanged and tears
+// down the connection instead of letting registration complete, so a
+// server that fails a SCRAM signature check (or otherwise can't be
+// trusted) is treated the same as any other rejected login.
+func (c *Client) abortSASL(err error) {
+	c.connChange(false, err)
+	close(c.quit)
+}
+
+// authenticate sends a base64-encoded SASL payload as one or more
+// AUTHENTICATE lines, per the IRCv3 SASL chunking rule.
+func (c *Client) authenticate(payload []byte) {
+	enc := base64.StdEncoding.EncodeToString(payload)
+
+	for len(enc) >= saslAuthenticateLineLen {
+		c.Write("AUTHENTICATE " + enc[:saslAuthenticateLineLen])
+		enc = enc[saslAuthenticateLineLen:]
+	}
+
+	if enc == "" {
+		c.Write("AUTHENTICATE +")
+		return
+	}
+
+	c.Write("AUTHENTICATE " + enc)
+}
+
+// beginSASLExternal starts the SASL EXTERNAL mechanism, used for CertFP
+// authentication where the client TLS certificate configured in
+// Config.TLSConfig.Certificates identifies the account.
+func (c *Client) beginSASLExternal() {
+	c.Write("AUTHENTICATE EXTERNAL")
+}
+
+// continueSASLExternal replies to the server's "AUTHENTICATE +" with an
+// empty authzid, since identity is carried entirely by the certificate.
+func (c *Client) continueSASLExternal() {
+	c.authenticate([]byte{})
+}
+
+// scramState tracks an in-progress SASL SCRAM-SHA-256 exchange (RFC 5802).
+type scramState struct {
+	username string
+	password string
+
+	clientNonce             string
+	clientFirstBare         string
+	serverFirst             string
+	expectedServerSignature []byte
+}
+
+func newScramState(username, password string) *scramState {
+	return &scramState{
+		username:    username,
+		password:    password,
+		clientNonce: scramNonce(),
+	}
+}
+
+func scramNonce() string {
+	b := make([]byte, 18)
+	rand.Read(b)
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// beginSASLScram starts the exchange by selecting the mechanism and
+// preparing the client-first message, which handleAuthenticate sends once
+// the server answers with "AUTHENTICATE +".
+func (c *Client) beginSASLScram(username, password string) *scramState {
+	s := newScramState(username, password)
+	s.clientFirstBare = fmt.Sprintf("n=%s,r=%s", scramEscape(username), s.clientNonce)
+
+	c.Write("AUTHENTICATE SCRAM-SHA-256")
+
+	return s
+}
+
+// continueSASLScram handles the server-first message and sends the
+// client-final message with the computed proof.
+func (c *Client) continueSASLScram(s *scramState, serverFirst []byte) error {
+	s.serverFirst = string(serverFirst)
+
+	fields := parseScramFields(s.serverFirst)
+	serverNonce := fields["r"]
+	salt, err := base64.StdEncoding.DecodeString(fields["s"])
+	if err != nil {
+		return fmt.Errorf("irc: bad SCRAM salt: %w", err)
+	}
+
+	iterations := 4096
+	fmt.Sscanf(fields["i"], "%d", &iterations)
+
+	if !strings.HasPrefix(serverNonce, s.clientNonce) {
+		return ErrSASLFailed
+	}
+
+	clientFinalWithoutProof := "c=biws,r=" + serverNonce
+
+	saltedPassword := pbkdf2.Key([]byte(s.password), salt, iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	authMessage := s.clientFirstBare + "," + s.serverFirst + "," + clientFinalWithoutProof
+	clientSignature := hmacSHA256(storedKey[:], authMessage)
+
+	clientProof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		clientProof[i] = clientKey[i] ^ clientSignature[i]
+	}
+
+	serverKey := hmacSHA256(saltedPassword, "Server Key")
+	s.expectedServerSignature = hmacSHA256(serverKey, authMessage)
+
+	clientFinal := clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(clientProof)
+	c.authenticate([]byte(clientFinal))
+
+	return nil
+}
+
+// finishSASLScram verifies the server's final message against the
+// signature computed in continueSASLScram.
+func (s *scramState) finishSASLScram(serverFinal []byte) error {
+	fields := parseScramFields(string(serverFinal))
+
+	v, err := base64.StdEncoding.DecodeString(fields["v"])
+	if err != nil {
+		return fmt.Errorf("irc: bad SCRAM server signature: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare(v, s.expectedServerSignature) != 1 {
+		return ErrSASLFailed
+	}
+
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func parseScramFields(s string) map[string]string {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			fields[part[:i]] = part[i+1:]
+		}
+	}
+	return fields
+}
+
+func scramEscape(s string) string {
+	s = strings.ReplaceAll(s, "=", "=3D")
+	s = strings.ReplaceAll(s, ",", "=2C")
+	return s
+}
+
+// handleAuthenticate advances the in-progress SASL exchange selected by
+// Config.SASL.Mechanism in response to an AUTHENTICATE line from the
+// server. It's called from handleMessage.
+func (c *Client) handleAuthenticate(msg *Message) {
+	if c.Config.SASL == nil {
+		return
+	}
+
+	payload := msg.Trailing
+	if payload == "" && len(msg.Params) > 0 {
+		payload = msg.Params[0]
+	}
+
+	switch c.Config.SASL.Mechanism {
+	case "EXTERNAL":
+		if payload == "+" {
+			c.continueSASLExternal()
+		}
+
+	case "SCRAM-SHA-256":
+		if c.scram == nil {
+			return
+		}
+
+		if payload == "+" && c.scram.serverFirst == "" {
+			c.authenticate([]byte("n,," + c.scram.clientFirstBare))
+			return
+		}
+
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return
+		}
+
+		if c.scram.serverFirst == "" {
+			if err := c.continueSASLScram(c.scram, data); err != nil {
+				c.abortSASL(err)
+				return
+			}
+		} else {
+			if err := c.scram.finishSASLScram(data); err != nil {
+				// The server's final message didn't carry the expected
+				// signature, so it either doesn't know the password or
+				// isn't the server it claims to be. Treat that exactly
+				// like any other failed login rather than completing
+				// registration.
+				c.abortSASL(err)
+				return
+			}
+			c.Write("CAP END")
+		}
+
+	default:
+		if payload == "+" {
+			authzid := c.Config.SASL.Username
+			auth := authzid + "\x00" + c.Config.SASL.Username + "\x00" + c.Config.SASL.Password
+			c.authenticate([]byte(auth))
+		}
+	}
+}
+
+// runConnectCommands sends Config.ConnectCommands as raw lines once
+// registration completes (after RPL_WELCOME) and before auto-join, for
+// networks that require querying service bots before channels are joined.
+func (c *Client) runConnectCommands() {
+	for _, cmd := range c.Config.ConnectCommands {
+		c.Write(cmd)
+	}
+}
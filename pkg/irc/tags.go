@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+// Tags holds the parsed key/value pairs from an IRCv3 message-tags prefix
+// (the "@key=value;key2=value2 " portion that precedes a line).
+type Tags map[string]string
+
+// ParseTags parses the content of a message-tags prefix, without the
+// leading "@" or trailing space. Vendor-prefixed keys (vendor.tld/key) are
+// kept as-is so callers can match on the full key when needed.
+func ParseTags(raw string) Tags {
+	if raw == "" {
+		return nil
+	}
+
+	pairs := strings.Split(raw, ";")
+	tags := make(Tags, len(pairs))
+
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+
+		key := pair
+		value := ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key = pair[:i]
+			value = unescapeTagValue(pair[i+1:])
+		}
+
+		tags[key] = value
+	}
+
+	return tags
+}
+
+// Get returns the value for key and whether it was present.
+func (t Tags) Get(key string) (string, bool) {
+	v, ok := t[key]
+	return v, ok
+}
+
+// Time returns the parsed value of the "time" tag (the server-time
+// extension), or the zero Time if it is absent or malformed.
+func (t Tags) Time() (time.Time, bool) {
+	v, ok := t["time"]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return ts, true
+}
+
+var tagEscapes = strings.NewReplacer(
+	"\\:", ";",
+	"\\s", " ",
+	"\\\\", "\\",
+	"\\r", "\r",
+	"\\n", "\n",
+)
+
+func unescapeTagValue(v string) string {
+	if !strings.ContainsRune(v, '\\') {
+		return v
+	}
+	return tagEscapes.Replace(v)
+}
+
+var tagValueEscapes = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\:",
+	" ", "\\s",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+// EscapeTagValue escapes a value for use in an outgoing client-only tag.
+func EscapeTagValue(v string) string {
+	return tagValueEscapes.Replace(v)
+}
+
+// splitTags splits a raw IRC line into its optional leading message-tags
+// prefix and the remainder of the line. ok is false when line carries no
+// tags prefix.
+func splitTags(line string) (tags Tags, rest string, ok bool) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line, false
+	}
+
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return ParseTags(line[1:]), "", true
+	}
+
+	return ParseTags(line[1:i]), line[i+1:], true
+}
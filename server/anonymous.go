@@ -0,0 +1,249 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anonSpeakerTTL bounds how long a real nick keeps the same anonymous
+// alias within a channel. After it elapses a burst of messages from a
+// previously-seen nick is treated as a new speaker, so long-running
+// sessions don't accumulate an ever-growing set of aliases.
+const anonSpeakerTTL = 10 * time.Minute
+
+type anonSpeaker struct {
+	alias string
+	seen  time.Time
+}
+
+// anonTracker maps real nicks to their anonymous alias per channel, so
+// repeated messages from the same person within a window get the same
+// "anonymous" or "anonymous2" label instead of a fresh one each time.
+type anonTracker struct {
+	mu         sync.Mutex
+	channels   map[string]bool
+	speakers   map[string]map[string]*anonSpeaker // channel -> nick -> speaker
+	membership map[string]map[string]bool         // nick -> set of joined channels
+}
+
+func (a *anonTracker) isAnonymous(channel string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.channels[strings.ToLower(channel)]
+}
+
+func (a *anonTracker) set(channel string, on bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	channel = strings.ToLower(channel)
+
+	if a.channels == nil {
+		a.channels = make(map[string]bool)
+	}
+
+	if on {
+		a.channels[channel] = true
+	} else {
+		delete(a.channels, channel)
+		delete(a.speakers, channel)
+	}
+}
+
+// alias returns the anonymous identity to use for nick in channel,
+// assigning a fresh numbered one if nick hasn't spoken recently.
+func (a *anonTracker) alias(channel, nick string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	channel = strings.ToLower(channel)
+
+	if a.speakers == nil {
+		a.speakers = make(map[string]map[string]*anonSpeaker)
+	}
+	speakers, ok := a.speakers[channel]
+	if !ok {
+		speakers = make(map[string]*anonSpeaker)
+		a.speakers[channel] = speakers
+	}
+
+	now := time.Now()
+
+	// Expire speakers that haven't spoken within the window before doing
+	// anything else, so both the map and the alias numbering only ever
+	// reflect currently-live speakers rather than the channel's full
+	// history.
+	used := make(map[int]bool, len(speakers))
+	for n, s := range speakers {
+		if now.Sub(s.seen) >= anonSpeakerTTL {
+			delete(speakers, n)
+			continue
+		}
+		used[aliasNumber(s.alias)] = true
+	}
+
+	if s, ok := speakers[nick]; ok {
+		s.seen = now
+		return s.alias
+	}
+
+	num := 1
+	for used[num] {
+		num++
+	}
+
+	alias := "anonymous"
+	if num > 1 {
+		alias = "anonymous" + itoa(num)
+	}
+
+	speakers[nick] = &anonSpeaker{alias: alias, seen: now}
+
+	return alias
+}
+
+// aliasNumber extracts the numeric suffix from an alias produced by
+// alias(), so a freed slot (its speaker expired) can be reused instead of
+// the numbering climbing forever.
+func aliasNumber(alias string) int {
+	suffix := strings.TrimPrefix(alias, "anonymous")
+	if suffix == "" {
+		return 1
+	}
+
+	n := 0
+	for _, c := range suffix {
+		n = n*10 + int(c-'0')
+	}
+
+	return n
+}
+
+// join records that nick is now a member of channel, so a later QUIT
+// (which carries no channel param) can still be checked against
+// isAnonymous.
+func (a *anonTracker) join(channel, nick string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	channel = strings.ToLower(channel)
+
+	if a.membership == nil {
+		a.membership = make(map[string]map[string]bool)
+	}
+
+	m, ok := a.membership[nick]
+	if !ok {
+		m = make(map[string]bool)
+		a.membership[nick] = m
+	}
+
+	m[channel] = true
+}
+
+// part removes the channel membership recorded by join.
+func (a *anonTracker) part(channel, nick string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	channel = strings.ToLower(channel)
+
+	if m, ok := a.membership[nick]; ok {
+		delete(m, channel)
+		if len(m) == 0 {
+			delete(a.membership, nick)
+		}
+	}
+}
+
+// quit clears nick's membership and returns the anonymous channels it was
+// in, for the caller to mask a QUIT prefix against.
+func (a *anonTracker) quit(nick string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m := a.membership[nick]
+	delete(a.membership, nick)
+
+	var anon []string
+	for channel := range m {
+		if a.channels[channel] {
+			anon = append(anon, channel)
+		}
+	}
+
+	return anon
+}
+
+func itoa(n int) string {
+	if n == 0 {
+		return "0"
+	}
+
+	var b [20]byte
+	i := len(b)
+	for n > 0 {
+		i--
+		b[i] = byte('0' + n%10)
+		n /= 10
+	}
+
+	return string(b[i:])
+}
+
+// SetAnonymous turns the anonymous-speaker privacy mode on or off for a
+// channel. While it's on, PRIVMSG/NOTICE/JOIN/PART/QUIT messages from
+// members of that channel get a stable "anonymous" or "anonymousN" Prefix
+// and the Host "anonymous.". Of the WHOIS replies, only an
+// RPL_WHOISCHANNELS line whose channels are all anonymous is dropped; the
+// other WHOIS replies (RPL_WHOISUSER and the rest) pass through unchanged.
+func (i *IRC) SetAnonymous(channel string, on bool) {
+	i.anon.set(channel, on)
+}
+
+// maskIdentity returns the nick and host that should be shown to clients
+// for a message seen on channel from the given real nick/host, applying
+// the anonymous-channel and host-cloaking options.
+func (i *IRC) maskIdentity(channel, nick, host string) (string, string) {
+	if i.anon.isAnonymous(channel) {
+		return i.anon.alias(channel, nick), "anonymous."
+	}
+
+	return nick, i.cloakHost(host)
+}
+
+// cloakHost rewrites a hostname to a stable HMAC-SHA256 digest keyed by
+// HostCloakSecret, so the same host always produces the same cloak. recv
+// applies it to JOIN, PART, PRIVMSG, NOTICE and QUIT messages only; other
+// messages keep the real host.
+func (i *IRC) cloakHost(host string) string {
+	if i.HostCloakSecret == "" {
+		return host
+	}
+
+	mac := hmac.New(sha256.New, []byte(i.HostCloakSecret))
+	mac.Write([]byte(host))
+
+	return hex.EncodeToString(mac.Sum(nil)) + ".cloak"
+}
+
+// isWhoisSuppressed reports whether a WHOIS reply about nick should be
+// dropped because nick is only known to be present in anonymous channels.
+func (i *IRC) isWhoisSuppressed(channels []string) bool {
+	if len(channels) == 0 {
+		return false
+	}
+
+	for _, c := range channels {
+		if !i.anon.isAnonymous(c) {
+			return false
+		}
+	}
+
+	return true
+}
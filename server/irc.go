@@ -52,6 +52,7 @@ const (
 
 type Message struct {
 	Prefix   string
+	Host     string
 	Command  string
 	Params   []string
 	Trailing string
@@ -74,6 +75,14 @@ type IRC struct {
 	Password  string
 	Username  string
 	Realname  string
+
+	// HostCloakSecret, when set, makes cloakHost replace the Host of
+	// JOIN, PART, PRIVMSG, NOTICE and QUIT messages outside anonymous
+	// channels with an HMAC-SHA256 digest of the real host; other
+	// messages keep the real host.
+	HostCloakSecret string
+
+	anon anonTracker
 }
 
 func NewIRC(nick, username string) *IRC {
@@ -246,7 +255,43 @@ func (i *IRC) recv() {
 		}
 
 		msg := parseMessage(line)
-		msg.Prefix = parseUser(msg.Prefix)
+		msg.Prefix, msg.Host = splitUserHost(msg.Prefix)
+		nick := msg.Prefix
+
+		switch msg.Command {
+		case JOIN:
+			if len(msg.Params) > 0 {
+				i.anon.join(msg.Params[0], nick)
+				msg.Prefix, msg.Host = i.maskIdentity(msg.Params[0], nick, msg.Host)
+			}
+
+		case PART:
+			if len(msg.Params) > 0 {
+				msg.Prefix, msg.Host = i.maskIdentity(msg.Params[0], nick, msg.Host)
+				i.anon.part(msg.Params[0], nick)
+			}
+
+		case PRIVMSG, NOTICE:
+			if len(msg.Params) > 0 {
+				msg.Prefix, msg.Host = i.maskIdentity(msg.Params[0], nick, msg.Host)
+			}
+
+		case QUIT:
+			// QUIT carries no channel param, so whether to mask it depends
+			// on the anonymous-channel membership tracked from JOIN/PART.
+			if channels := i.anon.quit(nick); len(channels) > 0 {
+				msg.Prefix, msg.Host = i.anon.alias(channels[0], nick), "anonymous."
+			} else {
+				msg.Host = i.cloakHost(msg.Host)
+			}
+
+		case RPL_WHOISCHANNELS:
+			// Members of anonymous-only channels shouldn't leak which
+			// channels they're in via WHOIS.
+			if i.isWhoisSuppressed(strings.Fields(msg.Trailing)) {
+				continue
+			}
+		}
 
 		i.Messages <- msg
 
@@ -302,3 +347,15 @@ func parseUser(user string) string {
 	}
 	return user
 }
+
+// splitUserHost splits a nick!user@host prefix into the nick and host
+// parts. host is empty if prefix carries no host, such as a server name.
+func splitUserHost(prefix string) (nick, host string) {
+	nick = parseUser(prefix)
+
+	if i := strings.Index(prefix, "@"); i > 0 {
+		host = prefix[i+1:]
+	}
+
+	return nick, host
+}
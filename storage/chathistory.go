@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var bucketLastSeen = []byte("lastseen")
+
+// GetMessagesBefore returns up to n messages older than beforeID in the
+// given buffer, ordered oldest to newest, for the IRCv3 CHATHISTORY BEFORE
+// selector.
+func (u *User) GetMessagesBefore(server, channel string, beforeID uint64, n int) ([]Message, error) {
+	messages := make([]Message, 0, n)
+
+	u.messageLog.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMessages).Bucket([]byte(server + ":" + channel))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		c.Seek(idToBytes(beforeID))
+
+		buf := make([]Message, 0, n)
+		for k, v := c.Prev(); len(buf) < n && k != nil; k, v = c.Prev() {
+			m := Message{}
+			m.Unmarshal(v)
+			buf = append(buf, m)
+		}
+
+		for i := len(buf) - 1; i >= 0; i-- {
+			messages = append(messages, buf[i])
+		}
+
+		return nil
+	})
+
+	return messages, nil
+}
+
+// GetMessagesAfter returns up to n messages newer than afterID, ordered
+// oldest to newest, for the CHATHISTORY AFTER and LATEST selectors. Pass
+// afterID of 0 to fetch the n oldest messages in the buffer.
+func (u *User) GetMessagesAfter(server, channel string, afterID uint64, n int) ([]Message, error) {
+	messages := make([]Message, 0, n)
+
+	u.messageLog.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMessages).Bucket([]byte(server + ":" + channel))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		var k, v []byte
+		if afterID == 0 {
+			k, v = c.First()
+		} else {
+			c.Seek(idToBytes(afterID))
+			k, v = c.Next()
+		}
+
+		for ; len(messages) < n && k != nil; k, v = c.Next() {
+			m := Message{}
+			m.Unmarshal(v)
+			messages = append(messages, m)
+		}
+
+		return nil
+	})
+
+	return messages, nil
+}
+
+// GetMessagesAround returns up to n/2 messages on either side of aroundID,
+// for the CHATHISTORY AROUND selector.
+func (u *User) GetMessagesAround(server, channel string, aroundID uint64, n int) ([]Message, error) {
+	before, err := u.GetMessagesBefore(server, channel, aroundID, n/2)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := u.GetMessagesAfter(server, channel, aroundID-1, n-n/2)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(before, after...), nil
+}
+
+// Target describes a buffer with history, as returned by ListTargets for
+// the CHATHISTORY TARGETS selector.
+type Target struct {
+	Server       string    `json:"server"`
+	Channel      string    `json:"channel"`
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// ListTargets scans the buckets under bucketMessages for the given server
+// and returns up to limit of them, most recently active first.
+func (u *User) ListTargets(server string, limit int) ([]Target, error) {
+	var targets []Target
+
+	err := u.messageLog.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(bucketMessages)
+
+		return root.ForEach(func(name, _ []byte) error {
+			bucketKey := string(name)
+			idx := len(server) + 1
+			if len(bucketKey) <= idx || bucketKey[:len(server)] != server || bucketKey[len(server)] != ':' {
+				return nil
+			}
+
+			b := root.Bucket(name)
+			if b == nil {
+				return nil
+			}
+
+			_, v := b.Cursor().Last()
+			if v == nil {
+				return nil
+			}
+
+			m := Message{}
+			m.Unmarshal(v)
+
+			targets = append(targets, Target{
+				Server:       server,
+				Channel:      bucketKey[idx:],
+				LastActivity: time.Unix(m.Time, 0),
+			})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortTargetsByActivity(targets)
+	if limit > 0 && len(targets) > limit {
+		targets = targets[:limit]
+	}
+
+	return targets, nil
+}
+
+func sortTargetsByActivity(targets []Target) {
+	for i := 1; i < len(targets); i++ {
+		for j := i; j > 0 && targets[j].LastActivity.After(targets[j-1].LastActivity); j-- {
+			targets[j], targets[j-1] = targets[j-1], targets[j]
+		}
+	}
+}
+
+// SetLastSeen records the ID of the newest message a user has seen in a
+// buffer, so unread counts and "mark as read" survive across sessions.
+func (u *User) SetLastSeen(server, channel string, id uint64) error {
+	return u.messageLog.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketLastSeen)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(server+":"+channel), idToBytes(id))
+	})
+}
+
+// GetLastSeen returns the last-seen message ID recorded for a buffer, or 0
+// if none has been recorded.
+func (u *User) GetLastSeen(server, channel string) (uint64, error) {
+	var id uint64
+
+	err := u.messageLog.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketLastSeen)
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get([]byte(server + ":" + channel))
+		if v == nil {
+			return nil
+		}
+
+		id = binary.BigEndian.Uint64(v)
+		return nil
+	})
+
+	return id, err
+}
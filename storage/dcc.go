@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// DCCTransfer is the metadata kept for a completed or attempted DCC file
+// transfer, logged alongside messages so it shows up in history and
+// search the same way a shared link would.
+type DCCTransfer struct {
+	ID     uint64 `json:"id"`
+	Server string `json:"-"`
+	Nick   string `json:"nick"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	Sent   bool   `json:"sent"`
+	Time   int64  `json:"time"`
+}
+
+func (t DCCTransfer) Type() string {
+	return "dcc"
+}
+
+// LogDCCTransfer records a DCC file transfer and logs a companion message
+// in the same buffer so it appears inline in history.
+func (u *User) LogDCCTransfer(server, nick, file string, size int64, sent bool) error {
+	transfer := DCCTransfer{
+		Server: server,
+		Nick:   nick,
+		File:   file,
+		Size:   size,
+		Sent:   sent,
+		Time:   time.Now().Unix(),
+	}
+	bucketKey := server + ":" + nick
+
+	err := u.messageLog.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bucketDCC).CreateBucketIfNotExists([]byte(bucketKey))
+		if err != nil {
+			return err
+		}
+
+		transfer.ID, _ = b.NextSequence()
+
+		data, err := transfer.Marshal(nil)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(idToBytes(transfer.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	direction := "sent"
+	if !sent {
+		direction = "received"
+	}
+
+	return u.LogMessage(server, nick, nick, direction+" file: "+file+" ("+strconv.FormatInt(size, 10)+" bytes)")
+}
+
+var bucketDCC = []byte("dcc")
@@ -8,16 +8,17 @@ import (
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/search"
 	"github.com/boltdb/bolt"
 )
 
 type Message struct {
 	ID      uint64 `json:"id" bleve:"-"`
 	Server  string `json:"-" bleve:"server"`
-	From    string `json:"from" bleve:"-"`
+	From    string `json:"from" bleve:"from"`
 	To      string `json:"-" bleve:"to"`
 	Content string `json:"content" bleve:"content"`
-	Time    int64  `json:"time" bleve:"-"`
+	Time    int64  `json:"time" bleve:"time"`
 }
 
 func (m Message) Type() string {
@@ -25,12 +26,19 @@ func (m Message) Type() string {
 }
 
 func (u *User) LogMessage(server, from, to, content string) error {
+	return u.LogMessageAt(server, from, to, content, time.Now())
+}
+
+// LogMessageAt logs a message with an explicit timestamp, for messages
+// whose origin time is known independently of when it was received, such
+// as IRCv3 server-time tagged history replayed from a bouncer.
+func (u *User) LogMessageAt(server, from, to, content string, at time.Time) error {
 	message := Message{
 		Server:  server,
 		From:    from,
 		To:      to,
 		Content: content,
-		Time:    time.Now().Unix(),
+		Time:    at.Unix(),
 	}
 	bucketKey := server + ":" + to
 
@@ -114,25 +122,112 @@ func (u *User) GetMessages(server, channel string, count int, fromID uint64) ([]
 	return nil, nil
 }
 
-func (u *User) SearchMessages(server, channel, q string) ([]Message, error) {
-	serverQuery := bleve.NewMatchQuery(server)
-	serverQuery.SetField("server")
-	channelQuery := bleve.NewMatchQuery(channel)
-	channelQuery.SetField("to")
-	contentQuery := bleve.NewMatchQuery(q)
-	contentQuery.SetField("content")
-	contentQuery.SetFuzziness(2)
+// SearchQuery describes a full-text search over a user's message history.
+// All fields are optional; a query that sets none of Phrase, Regex, From,
+// Since, Until, Server or Channel matches every message.
+type SearchQuery struct {
+	Phrase  string
+	Regex   string
+	From    string
+	Since   time.Time
+	Until   time.Time
+	Server  string
+	Channel string
+
+	Limit  int
+	Offset int
+
+	Highlight bool
+}
+
+// SearchHit is a single SearchMessages result, carrying the byte offsets
+// of matched spans within Content when Highlight was requested.
+type SearchHit struct {
+	Message
+	Highlights []HighlightSpan `json:"highlights,omitempty"`
+}
+
+// HighlightSpan is a single matched span within a message's Content,
+// given as byte offsets so the frontend can slice the string directly.
+type HighlightSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
 
+// SearchMessages runs q against the user's message index, translating its
+// fields into a boolean query of match-phrase, regex, term, and
+// numeric-range sub-queries.
+func (u *User) SearchMessages(q SearchQuery) ([]SearchHit, error) {
 	query := bleve.NewBooleanQuery()
-	query.AddMust(serverQuery, channelQuery, contentQuery)
 
-	search := bleve.NewSearchRequest(query)
+	if q.Server != "" {
+		serverQuery := bleve.NewMatchQuery(q.Server)
+		serverQuery.SetField("server")
+		query.AddMust(serverQuery)
+	}
+
+	if q.Channel != "" {
+		channelQuery := bleve.NewMatchQuery(q.Channel)
+		channelQuery.SetField("to")
+		query.AddMust(channelQuery)
+	}
+
+	if q.From != "" {
+		fromQuery := bleve.NewTermQuery(q.From)
+		fromQuery.SetField("from")
+		query.AddMust(fromQuery)
+	}
+
+	if q.Phrase != "" {
+		phraseQuery := bleve.NewMatchPhraseQuery(q.Phrase)
+		phraseQuery.SetField("content")
+		query.AddMust(phraseQuery)
+	}
+
+	if q.Regex != "" {
+		regexQuery := bleve.NewRegexpQuery(q.Regex)
+		regexQuery.SetField("content")
+		query.AddMust(regexQuery)
+	}
+
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		min, max := float64(0), float64(0)
+		var minPtr, maxPtr *float64
+		if !q.Since.IsZero() {
+			min = float64(q.Since.Unix())
+			minPtr = &min
+		}
+		if !q.Until.IsZero() {
+			max = float64(q.Until.Unix())
+			maxPtr = &max
+		}
+
+		timeQuery := bleve.NewNumericRangeQuery(minPtr, maxPtr)
+		timeQuery.SetField("time")
+		query.AddMust(timeQuery)
+	}
+
+	if len(query.Must.(*bleve.ConjunctionQuery).Conjuncts) == 0 {
+		query.AddMust(bleve.NewMatchAllQuery())
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	search := bleve.NewSearchRequestOptions(query, limit, q.Offset, false)
+	if q.Highlight {
+		search.Highlight = bleve.NewHighlight()
+		search.Fields = []string{"content"}
+	}
+
 	searchResults, err := u.messageIndex.Search(search)
 	if err != nil {
 		return nil, err
 	}
 
-	messages := []Message{}
+	hits := []SearchHit{}
 	u.messageLog.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(bucketMessages)
 
@@ -143,13 +238,112 @@ func (u *User) SearchMessages(server, channel, q string) ([]Message, error) {
 
 			message := Message{}
 			message.Unmarshal(bc.Get(idToBytes(id)))
-			messages = append(messages, message)
+
+			hits = append(hits, SearchHit{
+				Message:    message,
+				Highlights: highlightSpans(message.Content, hit),
+			})
 		}
 
 		return nil
 	})
 
-	return messages, nil
+	return hits, nil
+}
+
+func highlightSpans(content string, hit *search.DocumentMatch) []HighlightSpan {
+	locations, ok := hit.Locations["content"]
+	if !ok {
+		return nil
+	}
+
+	var spans []HighlightSpan
+	for _, matches := range locations {
+		for _, loc := range matches {
+			spans = append(spans, HighlightSpan{
+				Start: int(loc.Start),
+				End:   int(loc.End),
+			})
+		}
+	}
+
+	return spans
+}
+
+// DayCount is the number of messages logged on a single day, as returned
+// by CountByDay.
+type DayCount struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// maxCountByDayWindow bounds how many day-range facet buckets CountByDay
+// will ever build in one search request. Without a cap, a query that
+// leaves Since/Until unset would span from the Unix epoch to tomorrow and
+// ask Bleve to compute tens of thousands of facet ranges for a single
+// request.
+const maxCountByDayWindow = 366
+
+// CountByDay returns the number of messages per day matching q.Server and
+// q.Channel between q.Since and q.Until; q.Phrase, q.Regex, q.From,
+// q.Limit and q.Offset are not applied. It is backed by a date-range
+// facet so the frontend can
+// render a scrollbar heatmap of activity without pulling every message.
+// The window is capped at maxCountByDayWindow days, trimmed from the
+// start of the range, so an unbounded or accidentally huge Since/Until
+// can't force one request to build an unbounded number of facet buckets.
+func (u *User) CountByDay(q SearchQuery) ([]DayCount, error) {
+	q.Limit = 1
+	q.Highlight = false
+
+	query := bleve.NewBooleanQuery()
+	if q.Server != "" {
+		serverQuery := bleve.NewMatchQuery(q.Server)
+		serverQuery.SetField("server")
+		query.AddMust(serverQuery)
+	}
+	if q.Channel != "" {
+		channelQuery := bleve.NewMatchQuery(q.Channel)
+		channelQuery.SetField("to")
+		query.AddMust(channelQuery)
+	}
+	if len(query.Must.(*bleve.ConjunctionQuery).Conjuncts) == 0 {
+		query.AddMust(bleve.NewMatchAllQuery())
+	}
+
+	search := bleve.NewSearchRequestOptions(query, 0, 0, false)
+
+	facet := bleve.NewFacetRequest("time", maxCountByDayWindow)
+	until := q.Until
+	if until.IsZero() {
+		until = time.Now().AddDate(0, 0, 1)
+	}
+	since := q.Since
+	if since.IsZero() || until.Sub(since) > maxCountByDayWindow*24*time.Hour {
+		since = until.AddDate(0, 0, -maxCountByDayWindow)
+	}
+
+	for d := since; d.Before(until); d = d.AddDate(0, 0, 1) {
+		start := d
+		end := d.AddDate(0, 0, 1)
+		facet.AddDateTimeRange(start.Format("2006-01-02"), start, end)
+	}
+
+	search.AddFacet("byDay", facet)
+
+	searchResults, err := u.messageIndex.Search(search)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []DayCount{}
+	if dayFacet, ok := searchResults.Facets["byDay"]; ok {
+		for _, r := range dayFacet.DateRanges {
+			counts = append(counts, DayCount{Day: r.Name, Count: r.Count})
+		}
+	}
+
+	return counts, nil
 }
 
 func (u *User) openMessageLog() error {
@@ -165,6 +359,7 @@ func (u *User) openMessageLog() error {
 
 	u.messageLog.Update(func(tx *bolt.Tx) error {
 		tx.CreateBucketIfNotExists(bucketMessages)
+		tx.CreateBucketIfNotExists(bucketDCC)
 
 		return nil
 	})
@@ -180,15 +375,21 @@ func (u *User) openMessageLog() error {
 
 		contentMapping := bleve.NewTextFieldMapping()
 		contentMapping.Analyzer = "en"
-		contentMapping.Store = false
-		contentMapping.IncludeTermVectors = false
+		contentMapping.Store = true
+		contentMapping.IncludeTermVectors = true
 		contentMapping.IncludeInAll = false
 
+		timeMapping := bleve.NewNumericFieldMapping()
+		timeMapping.Store = false
+		timeMapping.IncludeInAll = false
+
 		messageMapping := bleve.NewDocumentMapping()
 		messageMapping.StructTagKey = "bleve"
 		messageMapping.AddFieldMappingsAt("server", keywordMapping)
 		messageMapping.AddFieldMappingsAt("to", keywordMapping)
+		messageMapping.AddFieldMappingsAt("from", keywordMapping)
 		messageMapping.AddFieldMappingsAt("content", contentMapping)
+		messageMapping.AddFieldMappingsAt("time", timeMapping)
 
 		mapping := bleve.NewIndexMapping()
 		mapping.AddDocumentMapping("message", messageMapping)
@@ -199,11 +400,88 @@ func (u *User) openMessageLog() error {
 		}
 	} else if err != nil {
 		return err
+	} else {
+		if err := u.reindexIfStale(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+var bucketMeta = []byte("meta")
+
+// searchSchemaVersion bumps whenever the Bleve mapping gains or changes a
+// field, so existing indexes can be rebuilt to pick it up. Version 2 added
+// the "from" keyword field and the numeric "time" field used by
+// SearchQuery.From/Since/Until and CountByDay.
+const searchSchemaVersion = 2
+
+// reindexIfStale rebuilds the message index from the bolt log when it was
+// built against an older mapping, so SearchQuery.From/Since/Until and
+// CountByDay work for history logged before this version.
+func (u *User) reindexIfStale() error {
+	var version int
+
+	u.messageLog.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMeta)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte("searchSchemaVersion")); v != nil {
+			version, _ = strconv.Atoi(string(v))
+		}
+		return nil
+	})
+
+	if version >= searchSchemaVersion {
+		return nil
+	}
+
+	if err := u.reindexMessages(); err != nil {
+		return err
+	}
+
+	return u.messageLog.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("searchSchemaVersion"), []byte(strconv.Itoa(searchSchemaVersion)))
+	})
+}
+
+// reindexMessages walks every bucket under bucketMessages and re-indexes
+// each message, for schema upgrades that add or change indexed fields.
+func (u *User) reindexMessages() error {
+	batch := u.messageIndex.NewBatch()
+
+	err := u.messageLog.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(bucketMessages)
+
+		return root.ForEach(func(name, _ []byte) error {
+			b := root.Bucket(name)
+			if b == nil {
+				return nil
+			}
+
+			bucketKey := string(name)
+
+			return b.ForEach(func(k, v []byte) error {
+				message := Message{}
+				message.Unmarshal(v)
+
+				return batch.Index(bucketKey+":"+strconv.FormatUint(message.ID, 10), message)
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return u.messageIndex.Batch(batch)
+}
+
 func (u *User) closeMessageLog() {
 	u.messageLog.Close()
 	u.messageIndex.Close()